@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultProfileName используется, если пользователь не указал --profile.
+const defaultProfileName = "svtav1"
+
+// Profile описывает один закодированный профиль конвертации: кодек, контейнер,
+// параметры качества и звук. Профили могут быть встроенными (builtinProfiles)
+// или загруженными из конфигурационного файла (--config).
+type Profile struct {
+	Name string // Идентификатор профиля, используется в --profile
+
+	Container string // Расширение выходного файла, включая точку, например ".mkv"
+
+	VideoCodec  string   // Значение -c:v
+	RateControl []string // Аргументы управления битрейтом/качеством, например {"-crf", "25"}
+	Preset      string   // Значение -preset, пусто = не передавать
+	HWAccel     string   // Значение -hwaccel (аппаратное ускорение декодирования), пусто = нет
+
+	// ThreadsArg - шаблон дополнительного аргумента, зависящего от числа потоков
+	// (например, "-svtav1-params lp=%d"). "%d" заменяется на количество потоков.
+	ThreadsArg string
+
+	AudioCodec   string // Значение -c:a, пусто = не перекодировать звук
+	AudioBitrate string // Значение -b:a, пусто = не передавать
+
+	ExtraArgs []string // Дополнительные аргументы ffmpeg, добавляются перед выходным файлом
+
+	// SourceExts - расширения исходных файлов, которые ищет этот профиль.
+	// Если не задано, используется sourceExtensions по умолчанию.
+	SourceExts []string
+}
+
+// buildArgs формирует полный список аргументов ffmpeg для конвертации
+// input -> output данным профилем с заданным числом потоков.
+func (p Profile) buildArgs(threads int, input, output string) []string {
+	var args []string
+
+	if p.HWAccel != "" {
+		args = append(args, "-hwaccel", p.HWAccel)
+	}
+
+	args = append(args, "-i", input, "-threads", strconv.Itoa(threads), "-c:v", p.VideoCodec)
+	args = append(args, p.RateControl...)
+
+	if p.Preset != "" {
+		args = append(args, "-preset", p.Preset)
+	}
+	if p.ThreadsArg != "" {
+		args = append(args, strings.Fields(fmt.Sprintf(p.ThreadsArg, threads))...)
+	}
+	if p.AudioCodec != "" {
+		args = append(args, "-c:a", p.AudioCodec)
+	}
+	if p.AudioBitrate != "" {
+		args = append(args, "-b:a", p.AudioBitrate)
+	}
+
+	args = append(args, p.ExtraArgs...)
+	args = append(args, "-progress", "pipe:1", "-nostats", output)
+
+	return args
+}
+
+// sourceExts возвращает расширения исходных файлов для этого профиля,
+// подставляя значение по умолчанию, если профиль его не переопределяет.
+func (p Profile) sourceExts() []string {
+	if len(p.SourceExts) > 0 {
+		return p.SourceExts
+	}
+	return strings.Split(sourceExtensions, ",")
+}
+
+// builtinProfiles возвращает набор профилей, поставляемых вместе с программой.
+func builtinProfiles() map[string]Profile {
+	profiles := []Profile{
+		{
+			Name:         "svtav1",
+			Container:    ".mkv",
+			VideoCodec:   "libsvtav1",
+			RateControl:  []string{"-crf", "25"},
+			Preset:       "8",
+			ThreadsArg:   "-svtav1-params lp=%d",
+			AudioCodec:   "aac",
+			AudioBitrate: "128k",
+		},
+		{
+			Name:         "x264",
+			Container:    ".mkv",
+			VideoCodec:   "libx264",
+			RateControl:  []string{"-crf", "23"},
+			Preset:       "medium",
+			AudioCodec:   "aac",
+			AudioBitrate: "128k",
+		},
+		{
+			Name:         "x265",
+			Container:    ".mkv",
+			VideoCodec:   "libx265",
+			RateControl:  []string{"-crf", "28"},
+			Preset:       "medium",
+			AudioCodec:   "aac",
+			AudioBitrate: "128k",
+		},
+		{
+			Name:         "vp9",
+			Container:    ".webm",
+			VideoCodec:   "libvpx-vp9",
+			RateControl:  []string{"-crf", "31", "-b:v", "0"},
+			AudioCodec:   "libopus",
+			AudioBitrate: "128k",
+		},
+		{
+			Name:         "h264_nvenc",
+			Container:    ".mkv",
+			VideoCodec:   "h264_nvenc",
+			RateControl:  []string{"-cq", "23"},
+			Preset:       "p5",
+			HWAccel:      "cuda",
+			AudioCodec:   "aac",
+			AudioBitrate: "128k",
+		},
+		{
+			Name:         "hevc_vaapi",
+			Container:    ".mkv",
+			VideoCodec:   "hevc_vaapi",
+			RateControl:  []string{"-qp", "28"},
+			HWAccel:      "vaapi",
+			ExtraArgs:    []string{"-vf", "format=nv12,hwupload"},
+			AudioCodec:   "aac",
+			AudioBitrate: "128k",
+		},
+		{
+			Name:         "hevc_videotoolbox",
+			Container:    ".mkv",
+			VideoCodec:   "hevc_videotoolbox",
+			RateControl:  []string{"-b:v", "4M"},
+			HWAccel:      "videotoolbox",
+			AudioCodec:   "aac",
+			AudioBitrate: "128k",
+		},
+	}
+
+	result := make(map[string]Profile, len(profiles))
+	for _, p := range profiles {
+		result[p.Name] = p
+	}
+	return result
+}
+
+// hwProfilePriority - порядок, в котором auto-hw пробует аппаратные профили.
+var hwProfilePriority = []string{"h264_nvenc", "hevc_vaapi", "hevc_videotoolbox"}
+
+// resolveProfile выбирает профиль по имени среди пользовательских (из --config)
+// и встроенных профилей. Имя "auto-hw" выбирает первый поддерживаемый
+// аппаратный профиль по результатам probeHWAccels, иначе - svtav1.
+func resolveProfile(name string, custom map[string]Profile) (Profile, error) {
+	if name == "auto-hw" {
+		accels, err := probeHWAccels()
+		if err != nil {
+			return Profile{}, fmt.Errorf("определение аппаратного ускорения: %w", err)
+		}
+		for _, candidate := range hwProfilePriority {
+			if accels[hwAccelFor(candidate)] {
+				return resolveProfile(candidate, custom)
+			}
+		}
+		return resolveProfile(defaultProfileName, custom)
+	}
+
+	if p, ok := custom[name]; ok {
+		return p, nil
+	}
+	if p, ok := builtinProfiles()[name]; ok {
+		return p, nil
+	}
+
+	return Profile{}, fmt.Errorf("неизвестный профиль: %s", name)
+}
+
+// hwAccelFor возвращает название метода -hwaccel, соответствующее встроенному
+// аппаратному профилю, чтобы сверить его со списком из probeHWAccels.
+func hwAccelFor(profileName string) string {
+	switch profileName {
+	case "h264_nvenc":
+		return "cuda"
+	case "hevc_vaapi":
+		return "vaapi"
+	case "hevc_videotoolbox":
+		return "videotoolbox"
+	default:
+		return ""
+	}
+}
+
+// probeHWAccels запускает `ffmpeg -hwaccels` и возвращает множество поддерживаемых
+// методов аппаратного ускорения, сообщаемых текущей сборкой ffmpeg.
+func probeHWAccels() (map[string]bool, error) {
+	out, err := exec.Command(ffmpegPath, "-hwaccels").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg -hwaccels: %w", err)
+	}
+
+	accels := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	inList := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "Hardware acceleration methods:" {
+			inList = true
+			continue
+		}
+		if inList && line != "" {
+			accels[line] = true
+		}
+	}
+
+	return accels, nil
+}
+
+// yamlProfile - схема одного профиля в YAML-файле конфигурации, см. loadProfilesConfig.
+// crf и bitrate взаимоисключающие: crf дает RateControl {-crf, значение},
+// bitrate - {-b:v, значение}; если заданы оба, побеждает crf.
+type yamlProfile struct {
+	Container    string   `yaml:"container"`
+	Codec        string   `yaml:"codec"`
+	CRF          string   `yaml:"crf"`
+	Bitrate      string   `yaml:"bitrate"`
+	Preset       string   `yaml:"preset"`
+	HWAccel      string   `yaml:"hwaccel"`
+	ThreadsArg   string   `yaml:"threads_arg"`
+	AudioCodec   string   `yaml:"audio_codec"`
+	AudioBitrate string   `yaml:"audio_bitrate"`
+	Extra        []string `yaml:"extra"`
+	SourceExts   []string `yaml:"source_exts"`
+}
+
+// loadProfilesConfig читает пользовательские профили из YAML-файла вида:
+//
+//	svtav1_hq:
+//	  container: .mkv
+//	  codec: libsvtav1
+//	  crf: "25"
+//	  preset: "8"
+//	  hwaccel: cuda
+//	  threads_arg: -svtav1-params lp=%d
+//	  audio_codec: aac
+//	  audio_bitrate: 128k
+//	  extra: ["-extra-flag", "value"]
+//	  source_exts: [".mov", ".mp4"]
+func loadProfilesConfig(path string) (map[string]Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("чтение файла конфигурации профилей: %w", err)
+	}
+
+	var parsed map[string]yamlProfile
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("разбор файла конфигурации профилей %s: %w", path, err)
+	}
+
+	profiles := make(map[string]Profile, len(parsed))
+	for name, yp := range parsed {
+		p := Profile{
+			Name:         name,
+			Container:    yp.Container,
+			VideoCodec:   yp.Codec,
+			Preset:       yp.Preset,
+			HWAccel:      yp.HWAccel,
+			ThreadsArg:   yp.ThreadsArg,
+			AudioCodec:   yp.AudioCodec,
+			AudioBitrate: yp.AudioBitrate,
+			ExtraArgs:    yp.Extra,
+			SourceExts:   yp.SourceExts,
+		}
+
+		switch {
+		case yp.CRF != "":
+			p.RateControl = []string{"-crf", yp.CRF}
+		case yp.Bitrate != "":
+			p.RateControl = []string{"-b:v", yp.Bitrate}
+		}
+
+		profiles[name] = p
+	}
+
+	return profiles, nil
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Режимы флага --ffmpeg-download
+const (
+	ffmpegDownloadAuto  = "auto"  // скачать, только если ffmpeg не найден или слишком старый
+	ffmpegDownloadNever = "never" // никогда не скачивать, использовать только PATH/--ffmpeg-path
+	ffmpegDownloadForce = "force" // не использовать ffmpeg из PATH, всегда брать скачанную сборку (из кэша, если она уже скачана для текущего тега)
+)
+
+// minFFmpegVersion - минимальная версия ffmpeg, в которой есть стабильный libsvtav1.
+const minFFmpegVersion = "6.0"
+
+// ffmpegReleaseAPIURL отдает JSON с описанием текущего релиза BtbN/FFmpeg-Builds,
+// используется только для разового разрешения "latest" в конкретный тег перед
+// скачиванием - сам архив потом всегда берется по этому зафиксированному тегу,
+// а не по плавающему алиасу latest/download.
+const ffmpegReleaseAPIURL = "https://api.github.com/repos/BtbN/FFmpeg-Builds/releases/latest"
+
+// ffmpegReleaseDownloadURL - откуда скачиваются статические сборки ffmpeg, с учетом
+// конкретного тега релиза, см. resolveFFmpegReleaseTag.
+const ffmpegReleaseDownloadURL = "https://github.com/BtbN/FFmpeg-Builds/releases/download"
+
+// ffmpegPath и ffprobePath - пути к исполняемым файлам, используемым всей программой.
+// Заполняются resolveFFmpeg при старте; по умолчанию ищутся в PATH.
+var (
+	ffmpegPath  = "ffmpeg"
+	ffprobePath = "ffprobe"
+)
+
+// resolveFFmpeg определяет, какой ffmpeg использовать: явно заданный путь,
+// уже установленный в PATH (если подходит по версии), либо скачанная и
+// закэшированная статическая сборка. Обновляет ffmpegPath/ffprobePath.
+func resolveFFmpeg(explicitPath, downloadMode string) error {
+	if explicitPath != "" {
+		version, err := ffmpegVersion(explicitPath)
+		if err != nil {
+			return fmt.Errorf("проверка ffmpeg по пути '%s': %w", explicitPath, err)
+		}
+		fmt.Printf("Используется ffmpeg из --ffmpeg-path: %s (версия %s)\n", explicitPath, version)
+		ffmpegPath = explicitPath
+		ffprobePath = siblingBinary(explicitPath, "ffprobe")
+		return nil
+	}
+
+	if downloadMode != ffmpegDownloadForce {
+		if path, err := exec.LookPath("ffmpeg"); err == nil {
+			version, verr := ffmpegVersion(path)
+			switch {
+			case verr == nil && versionAtLeast(version, minFFmpegVersion):
+				ffmpegPath = path
+				ffprobePath = "ffprobe"
+				return nil
+			case downloadMode == ffmpegDownloadNever:
+				if verr != nil {
+					return fmt.Errorf("не удалось определить версию ffmpeg в PATH: %w", verr)
+				}
+				return fmt.Errorf("ffmpeg в PATH имеет версию %s, требуется не ниже %s", version, minFFmpegVersion)
+			}
+		} else if downloadMode == ffmpegDownloadNever {
+			return fmt.Errorf("ffmpeg не найден в PATH: %w", err)
+		}
+	}
+
+	path, err := downloadFFmpeg()
+	if err != nil {
+		return err
+	}
+	ffmpegPath = path
+	ffprobePath = siblingBinary(path, "ffprobe")
+	return nil
+}
+
+// siblingBinary возвращает путь к соседнему исполняемому файлу в том же каталоге,
+// что и ffmpegBinaryPath (используется, чтобы найти ffprobe рядом со скачанным ffmpeg).
+func siblingBinary(ffmpegBinaryPath, name string) string {
+	dir := filepath.Dir(ffmpegBinaryPath)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(dir, name)
+}
+
+// ffmpegVersionPattern разбирает первую строку вывода `ffmpeg -version`:
+// "ffmpeg version 6.1.1-... Copyright ..."
+var ffmpegVersionPattern = regexp.MustCompile(`^ffmpeg version (\S+)`)
+
+// ffmpegVersion запускает `<path> -version` и извлекает номер версии из баннера.
+func ffmpegVersion(path string) (string, error) {
+	out, err := exec.Command(path, "-version").Output()
+	if err != nil {
+		return "", err
+	}
+
+	matches := ffmpegVersionPattern.FindStringSubmatch(string(out))
+	if len(matches) < 2 {
+		return "", errors.New("не удалось разобрать версию из вывода ffmpeg -version")
+	}
+	return matches[1], nil
+}
+
+// versionAtLeast сравнивает номера версий вида "6.1.1" или "6.1.1-static"
+// по числовым компонентам major.minor[.patch].
+func versionAtLeast(version, minVersion string) bool {
+	parse := func(v string) [3]int {
+		v = strings.SplitN(v, "-", 2)[0]
+		parts := strings.SplitN(v, ".", 3)
+		var out [3]int
+		for i := 0; i < len(parts) && i < 3; i++ {
+			out[i], _ = strconv.Atoi(parts[i])
+		}
+		return out
+	}
+
+	a, b := parse(version), parse(minVersion)
+	for i := 0; i < 3; i++ {
+		if a[i] != b[i] {
+			return a[i] > b[i]
+		}
+	}
+	return true
+}
+
+// ffmpegCacheDir возвращает каталог для хранения скачанных сборок ffmpeg,
+// например $XDG_CACHE_HOME/crawler-video-converter/ffmpeg.
+func ffmpegCacheDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("определение каталога кэша: %w", err)
+	}
+	return filepath.Join(base, "crawler-video-converter", "ffmpeg"), nil
+}
+
+// ffmpegAssetName возвращает имя архива статической сборки BtbN/FFmpeg-Builds
+// для текущей операционной системы и архитектуры.
+func ffmpegAssetName() (string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		switch runtime.GOARCH {
+		case "amd64":
+			return "ffmpeg-master-latest-linux64-gpl.tar.xz", nil
+		case "arm64":
+			return "ffmpeg-master-latest-linuxarm64-gpl.tar.xz", nil
+		}
+	case "windows":
+		if runtime.GOARCH == "amd64" {
+			return "ffmpeg-master-latest-win64-gpl.zip", nil
+		}
+	}
+	return "", fmt.Errorf("автоматическая загрузка ffmpeg не поддерживается для %s/%s, используйте --ffmpeg-path", runtime.GOOS, runtime.GOARCH)
+}
+
+// resolveFFmpegReleaseTag разрешает плавающий алиас "latest" BtbN/FFmpeg-Builds в
+// конкретный тег релиза через GitHub API. Это и есть закрепление версии: сам архив
+// потом скачивается по этому тегу, а не по вечно меняющемуся latest/download, так
+// что повторный запуск на той же машине переиспользует уже скачанную сборку этого
+// тега. Тег запрашивается заново при каждом запуске: после выхода нового тега в
+// апстриме скачивается сборка нового тега.
+func resolveFFmpegReleaseTag() (string, error) {
+	resp, err := http.Get(ffmpegReleaseAPIURL)
+	if err != nil {
+		return "", fmt.Errorf("запрос тега релиза ffmpeg: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("неожиданный статус ответа GitHub API (%s): %s", ffmpegReleaseAPIURL, resp.Status)
+	}
+
+	var parsed struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return "", fmt.Errorf("разбор ответа GitHub API: %w", err)
+	}
+	if parsed.TagName == "" {
+		return "", errors.New("GitHub API не вернул тег релиза ffmpeg")
+	}
+
+	return parsed.TagName, nil
+}
+
+// downloadFFmpeg разрешает "latest" в конкретный тег релиза, затем скачивает,
+// проверяет и распаковывает статическую сборку ffmpeg этого тега в каталог кэша
+// пользователя, возвращая путь к готовому бинарнику ffmpeg. Кэш разложен по тегу
+// релиза, поэтому закрепленная сборка переиспользуется, пока апстрим не выпустит
+// новый тег - в отличие от кэширования по имени файла архива, которое у BtbN
+// всегда одно и то же ("...-latest-...") независимо от фактического содержимого.
+func downloadFFmpeg() (string, error) {
+	asset, err := ffmpegAssetName()
+	if err != nil {
+		return "", err
+	}
+
+	cacheRoot, err := ffmpegCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	tag, err := resolveFFmpegReleaseTag()
+	if err != nil {
+		return "", fmt.Errorf("закрепление версии ffmpeg: %w", err)
+	}
+
+	destDir := filepath.Join(cacheRoot, tag)
+	binaryName := "ffmpeg"
+	if runtime.GOOS == "windows" {
+		binaryName = "ffmpeg.exe"
+	}
+	binPath := filepath.Join(destDir, binaryName)
+
+	if found, ferr := findBinary(destDir, binaryName); ferr == nil {
+		fmt.Printf("Используется ранее скачанный ffmpeg (закреплен на %s): %s\n", tag, found)
+		return found, nil
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return "", fmt.Errorf("создание каталога кэша %s: %w", destDir, err)
+	}
+
+	archivePath := filepath.Join(cacheRoot, tag+"-"+asset)
+	url := ffmpegReleaseDownloadURL + "/" + tag + "/" + asset
+
+	fmt.Printf("Скачивание ffmpeg %s: %s\n", tag, url)
+	sum, err := downloadFile(url, archivePath)
+	if err != nil {
+		return "", fmt.Errorf("скачивание %s: %w", url, err)
+	}
+
+	if err := verifyChecksum(url, archivePath, sum); err != nil {
+		os.Remove(archivePath)
+		return "", err
+	}
+
+	if err := extractArchive(archivePath, destDir); err != nil {
+		return "", fmt.Errorf("распаковка %s: %w", archivePath, err)
+	}
+	os.Remove(archivePath)
+
+	if _, err := os.Stat(binPath); err != nil {
+		found, ferr := findBinary(destDir, binaryName)
+		if ferr != nil {
+			return "", fmt.Errorf("бинарник ffmpeg не найден после распаковки: %w", ferr)
+		}
+		binPath = found
+	}
+
+	if runtime.GOOS != "windows" {
+		os.Chmod(binPath, 0755)
+	}
+
+	fmt.Printf("ffmpeg установлен в %s\n", binPath)
+	return binPath, nil
+}
+
+// downloadFile скачивает url в destPath и возвращает SHA-256 содержимого.
+func downloadFile(url, destPath string) (string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("неожиданный статус ответа: %s", resp.Status)
+	}
+
+	out, err := os.Create(destPath)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(out, io.TeeReader(resp.Body, hasher)); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// verifyChecksum сверяет посчитанный хэш с .sha256 файлом, публикуемым рядом с
+// релизом, если он доступен. Если сайдкар не найден, хэш лишь фиксируется в логе,
+// чтобы не блокировать установку на зеркалах без контрольных сумм.
+func verifyChecksum(assetURL, archivePath, actualSHA256 string) error {
+	sumResp, err := http.Get(assetURL + ".sha256")
+	if err != nil || sumResp.StatusCode != http.StatusOK {
+		fmt.Printf("[ПРЕДУПРЕЖДЕНИЕ] Контрольная сумма для %s недоступна, пропускаем проверку (sha256=%s)\n", filepath.Base(archivePath), actualSHA256)
+		if sumResp != nil {
+			sumResp.Body.Close()
+		}
+		return nil
+	}
+	defer sumResp.Body.Close()
+
+	body, err := io.ReadAll(sumResp.Body)
+	if err != nil {
+		return fmt.Errorf("чтение файла контрольной суммы: %w", err)
+	}
+
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		fmt.Printf("[ПРЕДУПРЕЖДЕНИЕ] Файл контрольной суммы для %s пуст, пропускаем проверку (sha256=%s)\n", filepath.Base(archivePath), actualSHA256)
+		return nil
+	}
+
+	expected := strings.ToLower(fields[0])
+	if expected != actualSHA256 {
+		return fmt.Errorf("несовпадение SHA-256: ожидалось %s, получено %s", expected, actualSHA256)
+	}
+
+	return nil
+}
+
+// extractArchive распаковывает .zip стандартной библиотекой, а .tar.xz - через
+// системную утилиту tar (в стандартной библиотеке Go нет декодера xz).
+func extractArchive(archivePath, destDir string) error {
+	switch {
+	case strings.HasSuffix(archivePath, ".zip"):
+		return extractZip(archivePath, destDir)
+	case strings.HasSuffix(archivePath, ".tar.xz"):
+		cmd := exec.Command("tar", "-xJf", archivePath, "-C", destDir, "--strip-components=1")
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stdout
+		return cmd.Run()
+	default:
+		return fmt.Errorf("неподдерживаемый формат архива: %s", archivePath)
+	}
+}
+
+// extractZip распаковывает zip-архив в destDir, разворачивая единственный
+// корневой каталог, который кладут в свои релизы сборки BtbN.
+func extractZip(archivePath, destDir string) error {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		relPath := f.Name
+		if idx := strings.IndexByte(relPath, '/'); idx >= 0 {
+			relPath = relPath[idx+1:]
+		}
+		if relPath == "" {
+			continue
+		}
+
+		target := filepath.Join(destDir, relPath)
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		src, err := f.Open()
+		if err != nil {
+			return err
+		}
+
+		dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+		if err != nil {
+			src.Close()
+			return err
+		}
+
+		_, copyErr := io.Copy(dst, src)
+		src.Close()
+		dst.Close()
+		if copyErr != nil {
+			return copyErr
+		}
+	}
+
+	return nil
+}
+
+// findBinary ищет файл с именем name внутри распакованного архива (сборки BtbN
+// кладут бинарники в подкаталог bin/).
+func findBinary(root, name string) (string, error) {
+	var found string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && info.Name() == name {
+			found = path
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, filepath.SkipAll) {
+		return "", err
+	}
+	if found == "" {
+		return "", fmt.Errorf("%s не найден в %s", name, root)
+	}
+	return found, nil
+}
+
+// printFFmpegVersionInfo выводит для команды `version` используемый бинарник ffmpeg и его баннер.
+func printFFmpegVersionInfo() error {
+	version, err := ffmpegVersion(ffmpegPath)
+	if err != nil {
+		return err
+	}
+	banner, err := exec.Command(ffmpegPath, "-version").Output()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("ffmpeg: %s\n", ffmpegPath)
+	fmt.Printf("версия: %s\n", version)
+	fmt.Print(string(banner))
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// JobStatus - состояние одной задачи конвертации в JobStore.
+type JobStatus string
+
+// Возможные состояния задачи.
+const (
+	JobPending  JobStatus = "pending"
+	JobRunning  JobStatus = "running"
+	JobDone     JobStatus = "done"
+	JobFailed   JobStatus = "failed"
+	JobCanceled JobStatus = "canceled"
+)
+
+// jobStoreFileName - имя файла хранилища задач, создаваемого в корне обрабатываемого каталога.
+const jobStoreFileName = ".crawler-video-converter.jobs.json"
+
+// jobLogTailLimit - сколько последних байт вывода ffmpeg сохранять в записи задачи при ошибке.
+const jobLogTailLimit = 4096
+
+// JobRecord описывает последнее известное состояние конвертации одного исходного файла.
+// Ключ записи строится по пути, размеру и времени модификации (см. jobKey), поэтому
+// изменение исходного файла приводит к повторной обработке, а переименование выходного
+// каталога - нет.
+type JobRecord struct {
+	SourcePath  string    `json:"source_path"`
+	OutputPath  string    `json:"output_path"`
+	ArgsHash    string    `json:"args_hash"`
+	Status      JobStatus `json:"status"`
+	ExitCode    int       `json:"exit_code"`
+	LogTail     string    `json:"log_tail,omitempty"`
+	Attempts    int       `json:"attempts"`
+	SourceBytes int64     `json:"source_bytes,omitempty"`
+	OutputBytes int64     `json:"output_bytes,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// JobStore - потокобезопасное хранилище состояний задач, сохраняемое в JSON-файл.
+// Позволяет безопасно останавливать и перезапускать обработку больших архивов.
+type JobStore struct {
+	mu   sync.Mutex
+	path string
+	jobs map[string]JobRecord
+}
+
+// openJobStore загружает хранилище задач из path, либо создает пустое, если файла еще нет.
+func openJobStore(path string) (*JobStore, error) {
+	store := &JobStore{path: path, jobs: make(map[string]JobRecord)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("чтение хранилища задач %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, &store.jobs); err != nil {
+		return nil, fmt.Errorf("разбор хранилища задач %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// save атомарно записывает текущее состояние хранилища на диск (запись во временный
+// файл и переименование, чтобы не оставить файл хранилища поврежденным при сбое).
+// Сериализация, запись и переименование выполняются под одним и тем же s.mu, а не
+// только сериализация - иначе параллельные воркеры (пул --jobs) гонятся за одним и
+// тем же путем ".tmp" и либо повреждают файл переплетенными записями, либо тихо
+// теряют чье-то сохранение при гонке переименований.
+func (s *JobStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(s.jobs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("сериализация хранилища задач: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("запись хранилища задач: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// jobKey формирует ключ задачи по исходному пути, размеру и времени модификации.
+func jobKey(file VideoFile, info os.FileInfo) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%d|%d", file.sourcePath, info.Size(), info.ModTime().UnixNano())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// get возвращает запись задачи по ключу, если она есть в хранилище.
+func (s *JobStore) get(key string) (JobRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.jobs[key]
+	return rec, ok
+}
+
+// all возвращает копию всех известных задач, ключ - id задачи (см. jobKey).
+func (s *JobStore) all() map[string]JobRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]JobRecord, len(s.jobs))
+	for id, rec := range s.jobs {
+		out[id] = rec
+	}
+	return out
+}
+
+// upsert обновляет запись задачи и сохраняет хранилище на диск.
+func (s *JobStore) upsert(key string, rec JobRecord) error {
+	rec.UpdatedAt = time.Now()
+
+	s.mu.Lock()
+	s.jobs[key] = rec
+	s.mu.Unlock()
+
+	return s.save()
+}
+
+// pending возвращает задачи в состояниях pending/running (не завершенные).
+func (s *JobStore) pending() []JobRecord {
+	return s.byStatus(JobPending, JobRunning)
+}
+
+// failed возвращает задачи в состоянии failed.
+func (s *JobStore) failed() []JobRecord {
+	return s.byStatus(JobFailed)
+}
+
+func (s *JobStore) byStatus(statuses ...JobStatus) []JobRecord {
+	want := make(map[JobStatus]bool, len(statuses))
+	for _, st := range statuses {
+		want[st] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []JobRecord
+	for _, rec := range s.jobs {
+		if want[rec.Status] {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// retryBackoff возвращает, сколько нужно ждать с момента последней попытки (UpdatedAt),
+// прежде чем повторно обрабатывать задачу, завершившуюся с ошибкой attempts раз подряд.
+func retryBackoff(attempts int) time.Duration {
+	d := time.Minute
+	for i := 0; i < attempts && d < time.Hour; i++ {
+		d *= 2
+	}
+	if d > time.Hour {
+		d = time.Hour
+	}
+	return d
+}
+
+// readyForRetry сообщает, прошло ли достаточно времени с прошлой неудачной попытки.
+func readyForRetry(rec JobRecord) bool {
+	return time.Since(rec.UpdatedAt) >= retryBackoff(rec.Attempts)
+}
+
+// tailBuffer - io.Writer, сохраняющий только последние limit байт записанных данных.
+// Используется, чтобы приложить хвост лога ffmpeg к записи о неудачной задаче.
+type tailBuffer struct {
+	data  []byte
+	limit int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.data = append(t.data, p...)
+	if len(t.data) > t.limit {
+		t.data = t.data[len(t.data)-t.limit:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.data)
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -14,21 +19,25 @@ import (
 	"sync"
 	"syscall"
 	"time"
+
+	"crawler-video-converter/progress"
 )
 
 // Константы программы
 const (
-	// Расширения исходных видео файлов
+	// Расширения исходных видео файлов по умолчанию (если профиль их не переопределяет)
 	// sourceExtensions = ".mov,.mp4,.avi"
 	sourceExtensions = ".mov"
-	// Выходное расширение
-	outputExtension = ".mkv"
 	// Имя каталога для конвертированных файлов
 	convertedDir = "converted"
 	// Nice level для процессов ffmpeg (0-19, больше = ниже приоритет)
 	niceLevel = 10
 	// Количество потоков ffmpeg по умолчанию
 	defaultThreads = 2
+	// Количество одновременных задач ffmpeg по умолчанию
+	defaultJobs = 1
+	// Период вывода агрегированного прогресса по всем воркерам
+	progressPrintInterval = 2 * time.Second
 )
 
 // VideoFile представляет видео файл для обработки
@@ -40,21 +49,59 @@ type VideoFile struct {
 
 // ProcessManager управляет процессами и обеспечивает корректное завершение
 type ProcessManager struct {
-	ctx       context.Context
-	cancel    context.CancelFunc
-	processes map[*exec.Cmd]bool
-	mu        sync.Mutex
-	wg        sync.WaitGroup
+	ctx        context.Context
+	cancel     context.CancelFunc
+	processes  map[*exec.Cmd]bool
+	jobCancels map[string]context.CancelFunc // id задачи (см. jobKey) -> отмена ее job-контекста
+	mu         sync.Mutex
+	wg         sync.WaitGroup
 }
 
 // NewProcessManager создает новый менеджер процессов
 func NewProcessManager() *ProcessManager {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &ProcessManager{
-		ctx:       ctx,
-		cancel:    cancel,
-		processes: make(map[*exec.Cmd]bool),
+		ctx:        ctx,
+		cancel:     cancel,
+		processes:  make(map[*exec.Cmd]bool),
+		jobCancels: make(map[string]context.CancelFunc),
+	}
+}
+
+// RegisterJob регистрирует функцию отмены контекста конкретной задачи, чтобы ее
+// можно было отменить по отдельности (например, через HTTP API) не затрагивая остальные.
+func (pm *ProcessManager) RegisterJob(id string, cancel context.CancelFunc) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.jobCancels[id] = cancel
+}
+
+// UnregisterJob убирает задачу из реестра после ее завершения.
+func (pm *ProcessManager) UnregisterJob(id string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	delete(pm.jobCancels, id)
+}
+
+// CancelJob отменяет контекст задачи с указанным id. Возвращает false, если задача
+// с таким id сейчас не выполняется.
+func (pm *ProcessManager) CancelJob(id string) bool {
+	pm.mu.Lock()
+	cancel, ok := pm.jobCancels[id]
+	pm.mu.Unlock()
+
+	if !ok {
+		return false
 	}
+	cancel()
+	return true
+}
+
+// ActiveJobs возвращает количество задач, выполняющихся в данный момент.
+func (pm *ProcessManager) ActiveJobs() int {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return len(pm.jobCancels)
 }
 
 // RegisterProcess регистрирует процесс для отслеживания
@@ -109,6 +156,18 @@ func (pm *ProcessManager) Shutdown() {
 }
 
 func main() {
+	// Подкоманда "version" печатает используемый ffmpeg и его версию без обработки файлов
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		runVersionCommand(os.Args[2:])
+		return
+	}
+
+	// Подкоманда "status" выводит незавершенные и проваленные задачи из хранилища каталога
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		runStatusCommand(os.Args[2:])
+		return
+	}
+
 	// Создаем менеджер процессов
 	pm := NewProcessManager()
 
@@ -128,15 +187,53 @@ func main() {
 	flag.IntVar(&threads, "threads", defaultThreads, "Количество потоков для ffmpeg")
 	flag.IntVar(&threads, "t", defaultThreads, "Количество потоков для ffmpeg (сокращенная форма)")
 
+	var jobs int
+	flag.IntVar(&jobs, "jobs", defaultJobs, "Количество файлов, обрабатываемых параллельно")
+	flag.IntVar(&jobs, "j", defaultJobs, "Количество файлов, обрабатываемых параллельно (сокращенная форма)")
+
+	var profileName string
+	flag.StringVar(&profileName, "profile", defaultProfileName, "Профиль кодирования (svtav1, x264, x265, vp9, h264_nvenc, hevc_vaapi, hevc_videotoolbox, auto-hw)")
+
+	var configPath string
+	flag.StringVar(&configPath, "config", "", "Путь к файлу с пользовательскими профилями кодирования")
+
+	var ffmpegExplicitPath string
+	flag.StringVar(&ffmpegExplicitPath, "ffmpeg-path", "", "Путь к бинарнику ffmpeg (иначе ищется в PATH или скачивается)")
+
+	var ffmpegDownload string
+	flag.StringVar(&ffmpegDownload, "ffmpeg-download", ffmpegDownloadAuto, "Когда скачивать ffmpeg: auto|never|force")
+
+	var resume bool
+	flag.BoolVar(&resume, "resume", false, "Пропускать файлы, уже отмеченные как done в хранилище задач")
+
+	var retryFailed bool
+	flag.BoolVar(&retryFailed, "retry-failed", false, "Повторно обрабатывать файлы, ранее завершившиеся с ошибкой")
+
+	var force bool
+	flag.BoolVar(&force, "force", false, "Игнорировать хранилище задач и существующие выходные файлы, обрабатывать заново")
+
+	var listenAddr string
+	flag.StringVar(&listenAddr, "listen", "", "Адрес для HTTP API и /metrics, например :8080 (включает режим сервера вместо одноразового запуска)")
+
+	var minBitrate int64
+	flag.Int64Var(&minBitrate, "min-bitrate", 0, "Пропускать исходники с битрейтом ниже этого значения (бит/с), 0 = выключено")
+
+	var minVMAF float64
+	flag.Float64Var(&minVMAF, "min-vmaf", 0, "Отклонять результат конвертации с VMAF ниже этого значения, 0 = проверка выключена")
+
+	var maxSizeRatio float64
+	flag.Float64Var(&maxSizeRatio, "max-size-ratio", 0, "Отклонять результат крупнее исходника более чем во столько раз, 0 = проверка выключена")
+
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Использование: %s [опции] <путь_к_каталогу>\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\nПрограмма рекурсивно обходит указанный каталог, находит видео файлы\n")
-		fmt.Fprintf(os.Stderr, "с расширениями %s и конвертирует их в формат MKV с кодеком AV1.\n", sourceExtensions)
+		fmt.Fprintf(os.Stderr, "с расширениями %s и конвертирует их согласно выбранному профилю кодирования.\n", sourceExtensions)
 		fmt.Fprintf(os.Stderr, "\nРезультаты сохраняются в подкаталог '%s' рядом с исходными файлами.\n", convertedDir)
 		fmt.Fprintf(os.Stderr, "\nОпции:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nОграничение нагрузки:\n")
 		fmt.Fprintf(os.Stderr, "  - Потоков ffmpeg: %d (по умолчанию)\n", defaultThreads)
+		fmt.Fprintf(os.Stderr, "  - Параллельных задач: %d (по умолчанию)\n", defaultJobs)
 		fmt.Fprintf(os.Stderr, "  - Nice level: %d (низкий приоритет)\n", niceLevel)
 	}
 	flag.Parse()
@@ -154,6 +251,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Проверка корректности количества параллельных задач
+	if jobs < 1 {
+		fmt.Fprintf(os.Stderr, "Ошибка: количество параллельных задач должно быть положительным числом\n")
+		os.Exit(1)
+	}
+
 	rootPath := flag.Arg(0)
 
 	// Проверка существования каталога
@@ -165,18 +268,68 @@ func main() {
 		log.Fatalf("Ошибка: '%s' не является каталогом", rootPath)
 	}
 
-	// Проверка наличия ffmpeg
-	if err := checkFFmpeg(); err != nil {
-		log.Fatalf("Ошибка: ffmpeg не найден или не доступен: %v\n", err)
+	// Убираем маркеры незавершенных файлов и сами незавершенные файлы, оставшиеся
+	// от упавшего или прерванного предыдущего запуска
+	cleanupIncompleteFiles(rootPath)
+
+	// Открываем хранилище задач для отслеживания done/failed между запусками
+	jobStore, err := openJobStore(filepath.Join(rootPath, jobStoreFileName))
+	if err != nil {
+		log.Fatalf("Ошибка открытия хранилища задач: %v", err)
+	}
+
+	// Определяем, какой ffmpeg использовать (PATH, --ffmpeg-path или скачанная сборка)
+	if err := resolveFFmpeg(ffmpegExplicitPath, ffmpegDownload); err != nil {
+		log.Fatalf("Ошибка: не удалось подготовить ffmpeg: %v\n", err)
+	}
+
+	// Загружаем пользовательские профили (если указан --config) и выбираем рабочий профиль
+	customProfiles := make(map[string]Profile)
+	if configPath != "" {
+		customProfiles, err = loadProfilesConfig(configPath)
+		if err != nil {
+			log.Fatalf("Ошибка загрузки конфигурации профилей: %v", err)
+		}
+	}
+
+	profile, err := resolveProfile(profileName, customProfiles)
+	if err != nil {
+		log.Fatalf("Ошибка выбора профиля кодирования: %v", err)
+	}
+
+	jobOpts := jobOptions{
+		store:        jobStore,
+		resume:       resume,
+		retryFailed:  retryFailed,
+		force:        force,
+		minBitrate:   minBitrate,
+		minVMAF:      minVMAF,
+		maxSizeRatio: maxSizeRatio,
+	}
+
+	// Режим сервера: вместо одноразовой обработки поднимаем HTTP API и запускаем
+	// первый обход каталога в фоне, чтобы сканирование и конвертацию можно было
+	// перезапускать и мониторить удаленно во время многочасовых батчей
+	if listenAddr != "" {
+		srv := newAPIServer(pm, rootPath, profile, threads, jobs, jobOpts)
+		srv.startScan()
+
+		fmt.Printf("HTTP API слушает на %s (GET /jobs, GET /jobs/{id}, POST /jobs/{id}/cancel, POST /scan, GET /healthz, GET /metrics)\n", listenAddr)
+		if err := http.ListenAndServe(listenAddr, srv.routes()); err != nil {
+			log.Fatalf("Ошибка HTTP API: %v", err)
+		}
+		return
 	}
 
 	fmt.Printf("Начинаем обработку каталога: %s\n", rootPath)
-	fmt.Printf("Поиск файлов с расширениями: %s\n", sourceExtensions)
+	fmt.Printf("Профиль кодирования: %s (%s -> %s)\n", profile.Name, profile.VideoCodec, profile.Container)
+	fmt.Printf("Поиск файлов с расширениями: %s\n", strings.Join(profile.sourceExts(), ","))
 	fmt.Printf("Потоков ffmpeg: %d\n", threads)
+	fmt.Printf("Параллельных задач: %d\n", jobs)
 	fmt.Printf("Nice level: %d\n\n", niceLevel)
 
 	// Поиск видео файлов
-	files, err := findVideoFiles(rootPath)
+	files, err := findVideoFiles(rootPath, profile.sourceExts())
 	if err != nil {
 		log.Fatalf("Ошибка при поиске файлов: %v", err)
 	}
@@ -188,8 +341,8 @@ func main() {
 
 	fmt.Printf("Найдено %d файлов для обработки\n\n", len(files))
 
-	// Обработка файлов последовательно
-	err = processFiles(files, pm, threads)
+	// Обработка файлов пулом воркеров с ограниченной параллельностью
+	err = processFiles(files, pm, threads, jobs, profile, jobOpts, progress.NewAggregateProgress())
 	if err != nil {
 		if err == context.Canceled {
 			fmt.Println("\n[ОТМЕНА] Обработка прервана пользователем")
@@ -199,16 +352,30 @@ func main() {
 	}
 }
 
-// checkFFmpeg проверяет наличие ffmpeg в системе
-func checkFFmpeg() error {
-	_, err := exec.LookPath("ffmpeg")
-	return err
+// runVersionCommand обрабатывает подкоманду `version`: разрешает ffmpeg так же,
+// как основной режим работы, и печатает выбранный бинарник и его версию.
+func runVersionCommand(args []string) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+
+	var ffmpegExplicitPath string
+	fs.StringVar(&ffmpegExplicitPath, "ffmpeg-path", "", "Путь к бинарнику ffmpeg")
+
+	var ffmpegDownload string
+	fs.StringVar(&ffmpegDownload, "ffmpeg-download", ffmpegDownloadAuto, "Когда скачивать ffmpeg: auto|never|force")
+
+	fs.Parse(args)
+
+	if err := resolveFFmpeg(ffmpegExplicitPath, ffmpegDownload); err != nil {
+		log.Fatalf("Ошибка: не удалось подготовить ffmpeg: %v\n", err)
+	}
+	if err := printFFmpegVersionInfo(); err != nil {
+		log.Fatalf("Ошибка получения версии ffmpeg: %v\n", err)
+	}
 }
 
-// findVideoFiles рекурсивно ищет видео файлы в каталоге
-func findVideoFiles(rootPath string) ([]VideoFile, error) {
+// findVideoFiles рекурсивно ищет в каталоге файлы с одним из расширений extensions
+func findVideoFiles(rootPath string, extensions []string) ([]VideoFile, error) {
 	var files []VideoFile
-	extensions := strings.Split(sourceExtensions, ",")
 
 	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -237,35 +404,101 @@ func findVideoFiles(rootPath string) ([]VideoFile, error) {
 	return files, err
 }
 
-// processFiles обрабатывает файлы последовательно
-func processFiles(files []VideoFile, pm *ProcessManager, threads int) error {
-	successCount := 0
-	skipCount := 0
-	errorCount := 0
-	canceledCount := 0
+// jobOptions группирует флаги, влияющие на то, как processFile использует JobStore
+// и применяет пороги качества/размера.
+type jobOptions struct {
+	store       *JobStore
+	resume      bool // пропускать файлы, уже отмеченные done в хранилище
+	retryFailed bool // повторно обрабатывать файлы, ранее завершившиеся с ошибкой
+	force       bool // игнорировать хранилище и существующие выходные файлы
+
+	minBitrate   int64   // пропускать исходники с битрейтом ниже этого значения (бит/с), 0 = выключено
+	minVMAF      float64 // отклонять результат с VMAF ниже этого значения, 0 = проверка выключена
+	maxSizeRatio float64 // отклонять результат крупнее исходника более чем во столько раз, 0 = выключено
+}
 
-	for _, file := range files {
-		// Проверяем, не был ли процесс прерван
-		if pm.ctx.Err() != nil {
-			canceledCount = len(files) - successCount - skipCount - errorCount
-			break
+// processFiles обрабатывает файлы пулом из `jobs` воркеров, каждый из которых
+// запускает ffmpeg с `threads` потоками и общим ограничением pm.ctx. reporter
+// передается вызывающей стороной, чтобы текущую скорость кодирования можно было
+// прочитать снаружи (например, из обработчика /metrics) во время выполнения.
+func processFiles(files []VideoFile, pm *ProcessManager, threads int, jobs int, profile Profile, jobOpts jobOptions, reporter *progress.AggregateProgress) error {
+	var (
+		mu            sync.Mutex
+		successCount  int
+		skipCount     int
+		errorCount    int
+		canceledCount int
+	)
+
+	queue := make(chan VideoFile)
+
+	// Периодически печатаем агрегированный прогресс всех активных воркеров
+	progressDone := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(progressPrintInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				reporter.PrintSummary()
+			case <-progressDone:
+				return
+			}
 		}
+	}()
 
-		pm.wg.Add(1)
-		result := processFile(file, pm, threads)
-		pm.wg.Done()
-
-		switch result {
-		case 0:
-			successCount++
-		case 1:
-			skipCount++
-		case 2:
-			errorCount++
-		case 3:
-			canceledCount++
+	var workers sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for file := range queue {
+				if pm.ctx.Err() != nil {
+					mu.Lock()
+					canceledCount++
+					mu.Unlock()
+					continue
+				}
+
+				pm.wg.Add(1)
+				result := processFile(file, pm, threads, reporter, profile, jobOpts)
+				pm.wg.Done()
+
+				mu.Lock()
+				switch result {
+				case 0:
+					successCount++
+				case 1:
+					skipCount++
+				case 2:
+					errorCount++
+				case 3:
+					canceledCount++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, file := range files {
+		select {
+		case queue <- file:
+		case <-pm.ctx.Done():
+			break feed
 		}
 	}
+	close(queue)
+	workers.Wait()
+	close(progressDone)
+
+	// Если отмена произошла уже после постановки в очередь, досчитываем оставшиеся файлы
+	mu.Lock()
+	processed := successCount + skipCount + errorCount + canceledCount
+	if pm.ctx.Err() != nil && processed < len(files) {
+		canceledCount += len(files) - processed
+	}
+	mu.Unlock()
 
 	fmt.Printf("\n===== Результаты обработки =====\n")
 	fmt.Printf("Успешно конвертировано: %d\n", successCount)
@@ -282,7 +515,7 @@ func processFiles(files []VideoFile, pm *ProcessManager, threads int) error {
 }
 
 // processFile обрабатывает один видео файл
-func processFile(file VideoFile, pm *ProcessManager, threads int) int {
+func processFile(file VideoFile, pm *ProcessManager, threads int, reporter progress.ProgressReporter, profile Profile, jobOpts jobOptions) int {
 	// Проверяем контекст перед началом
 	select {
 	case <-pm.ctx.Done():
@@ -297,6 +530,26 @@ func processFile(file VideoFile, pm *ProcessManager, threads int) int {
 		return 2
 	}
 
+	// Ключ задачи в хранилище: меняется при изменении содержимого исходного файла,
+	// но не зависит от имени/расположения выходного каталога
+	key := jobKey(file, sourceInfo)
+	existing, hasExisting := jobOpts.store.get(key)
+	attempts := existing.Attempts
+
+	if !jobOpts.force && hasExisting {
+		switch {
+		case existing.Status == JobDone && jobOpts.resume:
+			fmt.Printf("[ПРОПУЩЕН] %s (уже сконвертирован ранее)\n", file.fileName)
+			return 1
+		case existing.Status == JobFailed && !jobOpts.retryFailed:
+			fmt.Printf("[ПРОПУЩЕН] %s (ранее завершился с ошибкой, см. --retry-failed)\n", file.fileName)
+			return 1
+		case existing.Status == JobFailed && jobOpts.retryFailed && !readyForRetry(existing):
+			fmt.Printf("[ПРОПУЩЕН] %s (ожидание %s перед повторной попыткой)\n", file.fileName, retryBackoff(existing.Attempts))
+			return 1
+		}
+	}
+
 	// Создаем путь для выходного файла
 	convertedDir := filepath.Join(file.sourceDir, convertedDir)
 
@@ -308,17 +561,40 @@ func processFile(file VideoFile, pm *ProcessManager, threads int) int {
 
 	// Формируем имя выходного файла
 	nameWithoutExt := strings.TrimSuffix(file.fileName, filepath.Ext(file.fileName))
-	outputFileName := nameWithoutExt + outputExtension
+	outputFileName := nameWithoutExt + profile.Container
 	outputPath := filepath.Join(convertedDir, outputFileName)
 
-	// Проверяем, существует ли уже конвертированный файл
-	if _, err := os.Stat(outputPath); err == nil {
-		fmt.Printf("[ПРОПУЩЕН] %s (уже существует)\n", file.fileName)
+	// Проверяем, существует ли уже конвертированный файл (пропускаем эту проверку с --force)
+	if !jobOpts.force {
+		if _, err := os.Stat(outputPath); err == nil {
+			fmt.Printf("[ПРОПУЩЕН] %s (уже существует)\n", file.fileName)
+			return 1
+		}
+	}
+
+	// Предварительный анализ через ffprobe: пропускаем то, что уже не требует
+	// перекодирования, и подбираем CRF под разрешение/битрейт исходника, чтобы
+	// не пережимать то, что уже хорошо сжато. Заодно берем отсюда длительность,
+	// чтобы не запускать на один файл еще и отдельный progress.ProbeDuration
+	srcProbe, err := probeSource(file.sourcePath)
+	if err != nil {
+		log.Printf("[ПРЕДУПРЕЖДЕНИЕ] Не удалось получить параметры %s через ffprobe: %v", file.fileName, err)
+	} else if reason := skipReason(srcProbe, jobOpts.minBitrate); reason != "" {
+		fmt.Printf("[ПРОПУЩЕН] %s (%s)\n", file.fileName, reason)
 		return 1
+	} else {
+		profile = adaptiveProfile(profile, srcProbe)
 	}
 
 	fmt.Printf("[НАЧАЛО] %s (threads=%d)\n", file.fileName, threads)
 
+	// Регистрируем задачу под ее ключом хранилища, чтобы ее можно было отменить
+	// по отдельности (например, через POST /jobs/{id}/cancel), не затрагивая остальные
+	jobCtx, jobCancel := context.WithCancel(pm.ctx)
+	pm.RegisterJob(key, jobCancel)
+	defer pm.UnregisterJob(key)
+	defer jobCancel()
+
 	// Маркер для неполного файла
 	incompleteMarker := outputPath + ".incomplete"
 
@@ -335,26 +611,42 @@ func processFile(file VideoFile, pm *ProcessManager, threads int) int {
 		fmt.Printf("[ОЧИСТКА] Удален неполный файл: %s\n", outputFileName)
 	}
 
-	// Запускаем ffmpeg с ограничением потоков
-	var cmd *exec.Cmd
+	// Длительность исходника нужна, чтобы переводить out_time_ms в проценты готовности.
+	// Берем ее из предварительного probeSource, если он удался, и запускаем отдельный
+	// progress.ProbeDuration только если тот вызов не прошел или не вернул длительность
+	duration := srcProbe.Duration
+	if duration <= 0 {
+		duration, err = progress.ProbeDuration(ffprobePath, file.sourcePath)
+		if err != nil {
+			log.Printf("[ПРЕДУПРЕЖДЕНИЕ] Не удалось определить длительность %s: %v", file.fileName, err)
+		}
+	}
+
+	// Запускаем ffmpeg с ограничением потоков, используя аргументы выбранного профиля
+	ffmpegArgs := append([]string{ffmpegPath}, profile.buildArgs(threads, file.sourcePath, outputPath)...)
+	argsHash := hashArgs(ffmpegArgs)
+
+	saveJobRecord(jobOpts, file.fileName, key, JobRecord{
+		SourcePath: file.sourcePath,
+		OutputPath: outputPath,
+		ArgsHash:   argsHash,
+		Status:     JobRunning,
+		Attempts:   attempts + 1,
+	})
+
 	// На Unix-подобных системах используем nice
-	cmd = exec.Command("nice",
-		"-n", strconv.Itoa(niceLevel),
-		"ffmpeg",
-		"-i", file.sourcePath,
-		"-threads", strconv.Itoa(threads),
-		"-c:v", "libsvtav1",
-		"-crf", "25",
-		"-preset", "8",
-		"-svtav1-params", "lp="+strconv.Itoa(threads),
-		"-c:a", "aac",
-		"-b:a", "128k",
-		outputPath,
-	)
+	cmd := exec.Command("nice", append([]string{"-n", strconv.Itoa(niceLevel)}, ffmpegArgs...)...)
 
-	// Перенаправляем вывод ffmpeg
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stdout
+	// Вывод прогресса (-progress pipe:1) читаем отдельно, ошибки ffmpeg оставляем в stdout
+	// программы и одновременно сохраняем хвост лога для записи о неудачной задаче
+	progressPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Printf("[ОШИБКА] Не удалось открыть поток прогресса для %s: %v", file.fileName, err)
+		cleanup()
+		return 2
+	}
+	logTail := &tailBuffer{limit: jobLogTailLimit}
+	cmd.Stderr = io.MultiWriter(os.Stdout, logTail)
 
 	// Запускаем команду
 	err = cmd.Start()
@@ -364,6 +656,8 @@ func processFile(file VideoFile, pm *ProcessManager, threads int) int {
 		return 2
 	}
 
+	go progress.WatchProgress(progressPipe, file.fileName, duration, reporter)
+
 	// Регистрируем процесс
 	pm.RegisterProcess(cmd)
 
@@ -374,8 +668,9 @@ func processFile(file VideoFile, pm *ProcessManager, threads int) int {
 	}()
 
 	select {
-	case <-pm.ctx.Done():
-		// Контекст отменен, останавливаем процесс
+	case <-jobCtx.Done():
+		// Контекст отменен (завершение программы или отмена этой задачи через API),
+		// останавливаем процесс
 		if cmd.Process != nil {
 			cmd.Process.Signal(os.Interrupt)
 		}
@@ -390,6 +685,13 @@ func processFile(file VideoFile, pm *ProcessManager, threads int) int {
 		}
 		cleanup()
 		pm.UnregisterProcess(cmd)
+		saveJobRecord(jobOpts, file.fileName, key, JobRecord{
+			SourcePath: file.sourcePath,
+			OutputPath: outputPath,
+			ArgsHash:   argsHash,
+			Status:     JobCanceled,
+			Attempts:   attempts + 1,
+		})
 		return 3
 
 	case err := <-done:
@@ -399,6 +701,15 @@ func processFile(file VideoFile, pm *ProcessManager, threads int) int {
 		if err != nil {
 			log.Printf("[ОШИБКА] Ошибка конвертации %s: %v", file.fileName, err)
 			cleanup()
+			saveJobRecord(jobOpts, file.fileName, key, JobRecord{
+				SourcePath: file.sourcePath,
+				OutputPath: outputPath,
+				ArgsHash:   argsHash,
+				Status:     JobFailed,
+				ExitCode:   exitCode(err),
+				LogTail:    logTail.String(),
+				Attempts:   attempts + 1,
+			})
 			return 2
 		}
 
@@ -412,6 +723,40 @@ func processFile(file VideoFile, pm *ProcessManager, threads int) int {
 			log.Printf("[ПРЕДУПРЕЖДЕНИЕ] Не удалось сохранить дату файла %s: %v", file.fileName, err)
 		}
 
+		var outputBytes int64
+		if outInfo, err := os.Stat(outputPath); err == nil {
+			outputBytes = outInfo.Size()
+		}
+
+		// Проверка качества/размера результата (VMAF, отношение размеров), если пороги заданы
+		if reason := qualityRejection(jobOpts, file.fileName, file.sourcePath, outputPath, sourceInfo.Size(), outputBytes); reason != "" {
+			log.Printf("[ОТКЛОНЕНО] %s: %s", file.fileName, reason)
+			os.Remove(outputPath)
+			saveJobRecord(jobOpts, file.fileName, key, JobRecord{
+				SourcePath:  file.sourcePath,
+				OutputPath:  outputPath,
+				ArgsHash:    argsHash,
+				Status:      JobFailed,
+				ExitCode:    -1, // ffmpeg отработал успешно, отказ - по порогам качества/размера, а не по коду выхода
+				LogTail:     reason,
+				Attempts:    attempts + 1,
+				SourceBytes: sourceInfo.Size(),
+				OutputBytes: outputBytes,
+			})
+			return 2
+		}
+
+		saveJobRecord(jobOpts, file.fileName, key, JobRecord{
+			SourcePath:  file.sourcePath,
+			OutputPath:  outputPath,
+			ArgsHash:    argsHash,
+			Status:      JobDone,
+			ExitCode:    0,
+			Attempts:    attempts + 1,
+			SourceBytes: sourceInfo.Size(),
+			OutputBytes: outputBytes,
+		})
+
 		fmt.Printf("[УСПЕХ] %s -> %s\n", file.fileName, outputFileName)
 		return 0
 	}
@@ -425,10 +770,70 @@ func cleanupIncompleteFiles(rootPath string) {
 		}
 
 		if !info.IsDir() && strings.HasSuffix(path, ".incomplete") {
+			incompleteOutput := strings.TrimSuffix(path, ".incomplete")
+			os.Remove(incompleteOutput)
 			os.Remove(path)
-			fmt.Printf("[ОЧИСТКА] Удален маркер неполного файла: %s\n", filepath.Base(path))
+			fmt.Printf("[ОЧИСТКА] Удален маркер и незавершенный файл: %s\n", filepath.Base(incompleteOutput))
 		}
 
 		return nil
 	})
 }
+
+// saveJobRecord записывает запись задачи в хранилище, логируя предупреждение при ошибке
+// вместо прерывания обработки файла (потеря записи в хранилище не должна валить конвертацию).
+func saveJobRecord(jobOpts jobOptions, fileName string, key string, rec JobRecord) {
+	if err := jobOpts.store.upsert(key, rec); err != nil {
+		log.Printf("[ПРЕДУПРЕЖДЕНИЕ] Не удалось сохранить состояние задачи для %s: %v", fileName, err)
+	}
+}
+
+// hashArgs возвращает SHA-256 от списка аргументов ffmpeg, используемый для
+// быстрого сравнения, менялись ли параметры кодирования задачи между запусками.
+func hashArgs(args []string) string {
+	h := sha256.New()
+	for _, a := range args {
+		fmt.Fprintf(h, "%s\x00", a)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// exitCode извлекает код завершения процесса из ошибки cmd.Wait(), возвращая -1,
+// если код недоступен (например, процесс не удалось запустить).
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// runStatusCommand выводит незавершенные и проваленные задачи из хранилища
+// указанного каталога, не запуская обработку файлов.
+func runStatusCommand(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "Использование: crawler-video-converter status <путь_к_каталогу>")
+		os.Exit(1)
+	}
+
+	rootPath := fs.Arg(0)
+	store, err := openJobStore(filepath.Join(rootPath, jobStoreFileName))
+	if err != nil {
+		log.Fatalf("Ошибка открытия хранилища задач: %v", err)
+	}
+
+	pending := store.pending()
+	fmt.Printf("Ожидают/выполняются: %d\n", len(pending))
+	for _, rec := range pending {
+		fmt.Printf("  [%s] %s\n", rec.Status, rec.SourcePath)
+	}
+
+	failed := store.failed()
+	fmt.Printf("Завершились с ошибкой: %d\n", len(failed))
+	for _, rec := range failed {
+		fmt.Printf("  %s (попыток: %d, код выхода: %d)\n", rec.SourcePath, rec.Attempts, rec.ExitCode)
+	}
+}
@@ -0,0 +1,220 @@
+// Package progress разбирает построчный вывод `ffmpeg -progress pipe:1` и
+// агрегирует прогресс нескольких параллельных воркеров в одну сводку для пользователя.
+package progress
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressUpdate описывает промежуточное состояние конвертации одного файла.
+type ProgressUpdate struct {
+	File    string        // Имя обрабатываемого файла
+	Percent float64       // Процент готовности (0-100)
+	Speed   float64       // Текущая скорость кодирования относительно реального времени
+	ETA     time.Duration // Оценка оставшегося времени для этого файла
+	Done    bool          // true, когда ffmpeg сообщил progress=end
+}
+
+// ProgressReporter получает обновления хода конвертации и отображает их пользователю.
+type ProgressReporter interface {
+	Report(update ProgressUpdate)
+}
+
+// AggregateProgress собирает прогресс всех параллельных воркеров и периодически
+// печатает суммарную строку состояния вместо отдельной строки на файл.
+type AggregateProgress struct {
+	mu      sync.Mutex
+	workers map[string]ProgressUpdate
+}
+
+// NewAggregateProgress создает пустой агрегатор прогресса.
+func NewAggregateProgress() *AggregateProgress {
+	return &AggregateProgress{workers: make(map[string]ProgressUpdate)}
+}
+
+// Report сохраняет последнее состояние по файлу. Реализует ProgressReporter.
+func (a *AggregateProgress) Report(update ProgressUpdate) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if update.Done {
+		delete(a.workers, update.File)
+		return
+	}
+	a.workers[update.File] = update
+}
+
+// PrintSummary выводит одну строку со статусом всех активных файлов и общим ETA.
+func (a *AggregateProgress) PrintSummary() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.workers) == 0 {
+		return
+	}
+
+	var parts []string
+	var maxETA time.Duration
+	for _, u := range a.workers {
+		parts = append(parts, fmt.Sprintf("%s %.0f%%", u.File, u.Percent))
+		if u.ETA > maxETA {
+			maxETA = u.ETA
+		}
+	}
+
+	fmt.Printf("[ПРОГРЕСС] %s | общий ETA: %s\n", strings.Join(parts, ", "), formatETA(maxETA))
+}
+
+// AverageSpeed возвращает среднюю скорость кодирования активных воркеров
+// (во сколько раз быстрее реального времени), используется для /metrics.
+func (a *AggregateProgress) AverageSpeed() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.workers) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, u := range a.workers {
+		sum += u.Speed
+	}
+	return sum / float64(len(a.workers))
+}
+
+// formatETA форматирует оценку оставшегося времени для вывода пользователю.
+func formatETA(d time.Duration) string {
+	if d <= 0 {
+		return "неизвестно"
+	}
+	return d.Round(time.Second).String()
+}
+
+// progressParser разбирает построчный вывод `ffmpeg -progress pipe:1 -nostats`
+// и пересчитывает его в проценты готовности относительно известной длительности.
+type progressParser struct {
+	duration time.Duration
+
+	outTimeMs int64
+	speed     float64
+}
+
+// feed обрабатывает одну строку вида "key=value" из вывода ffmpeg.
+// Возвращает true, когда встречена строка "progress=end" (конец кодирования).
+func (p *progressParser) feed(line string) (finished bool) {
+	key, value, ok := splitProgressLine(line)
+	if !ok {
+		return false
+	}
+
+	switch key {
+	case "out_time_ms":
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			p.outTimeMs = v
+		}
+	case "speed":
+		if f, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+			p.speed = f
+		}
+	case "progress":
+		finished = value == "end"
+	}
+
+	return finished
+}
+
+// splitProgressLine разбирает строку "key=value", игнорируя пустые и некорректные строки.
+func splitProgressLine(line string) (key, value string, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", "", false
+	}
+
+	idx := strings.IndexByte(line, '=')
+	if idx < 0 {
+		return "", "", false
+	}
+
+	return line[:idx], strings.TrimSpace(line[idx+1:]), true
+}
+
+// percent возвращает текущий процент готовности относительно длительности источника.
+func (p *progressParser) percent() float64 {
+	if p.duration <= 0 {
+		return 0
+	}
+
+	pct := float64(p.outTimeMs) / 1000 / p.duration.Seconds() * 100
+	switch {
+	case pct > 100:
+		pct = 100
+	case pct < 0:
+		pct = 0
+	}
+	return pct
+}
+
+// eta оценивает оставшееся время конвертации по текущей скорости ffmpeg.
+func (p *progressParser) eta() time.Duration {
+	if p.speed <= 0 || p.duration <= 0 {
+		return 0
+	}
+
+	elapsed := time.Duration(p.outTimeMs) * time.Millisecond
+	remaining := p.duration - elapsed
+	if remaining <= 0 {
+		return 0
+	}
+	return time.Duration(float64(remaining) / p.speed)
+}
+
+// WatchProgress читает поток `-progress pipe:1` построчно и отправляет обновления в reporter.
+// Завершается, когда поток закрывается (процесс ffmpeg завершился).
+func WatchProgress(r io.Reader, file string, duration time.Duration, reporter ProgressReporter) {
+	if reporter == nil {
+		return
+	}
+
+	parser := &progressParser{duration: duration}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		finished := parser.feed(scanner.Text())
+		reporter.Report(ProgressUpdate{
+			File:    file,
+			Percent: parser.percent(),
+			Speed:   parser.speed,
+			ETA:     parser.eta(),
+			Done:    finished,
+		})
+	}
+}
+
+// ProbeDuration получает длительность исходного файла через ffprobe.
+// Используется как база для расчета процента готовности конвертации.
+func ProbeDuration(ffprobePath, path string) (time.Duration, error) {
+	out, err := exec.Command(ffprobePath,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe: %w", err)
+	}
+
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("разбор длительности из ffprobe: %w", err)
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
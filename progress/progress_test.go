@@ -0,0 +1,143 @@
+package progress
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitProgressLine(t *testing.T) {
+	cases := []struct {
+		name      string
+		line      string
+		wantKey   string
+		wantValue string
+		wantOK    bool
+	}{
+		{name: "simple pair", line: "speed=1.23x", wantKey: "speed", wantValue: "1.23x", wantOK: true},
+		{name: "trims whitespace", line: "  out_time_ms=500000  ", wantKey: "out_time_ms", wantValue: "500000", wantOK: true},
+		{name: "empty line", line: "", wantOK: false},
+		{name: "whitespace only", line: "   ", wantOK: false},
+		{name: "no equals sign is garbage", line: "garbage_no_equals", wantOK: false},
+		{name: "value itself may contain equals", line: "tag=a=b", wantKey: "tag", wantValue: "a=b", wantOK: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, value, ok := splitProgressLine(tc.line)
+			if ok != tc.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tc.wantOK)
+			}
+			if !tc.wantOK {
+				return
+			}
+			if key != tc.wantKey || value != tc.wantValue {
+				t.Fatalf("got (%q, %q), want (%q, %q)", key, value, tc.wantKey, tc.wantValue)
+			}
+		})
+	}
+}
+
+// recordedProgressLines - зафиксированный вывод `ffmpeg -progress pipe:1 -nostats`
+// для 100-секундного исходника, вперемешку со строками, которые встречаются в
+// реальных логах: без "=", с мусором вместо числа, и завершающий progress=end.
+var recordedProgressLines = []string{
+	"frame=100",
+	"fps=25.00",
+	"out_time_ms=25000",
+	"speed=0.5x",
+	"progress=continue",
+	"this line has no equals sign at all",
+	"",
+	"out_time_ms=not_a_number",
+	"out_time_ms=50000",
+	"speed=1.0x",
+	"progress=continue",
+	"out_time_ms=100000",
+	"speed=garbage",
+	"progress=continue",
+	"progress=end",
+}
+
+func TestProgressParserFeed(t *testing.T) {
+	p := &progressParser{duration: 100 * time.Second}
+
+	var finished bool
+	for _, line := range recordedProgressLines {
+		finished = p.feed(line)
+	}
+
+	if !finished {
+		t.Fatalf("feed() did not report finished after progress=end")
+	}
+	if got := p.percent(); got != 100 {
+		t.Fatalf("percent() = %v, want 100", got)
+	}
+	// Последняя строка speed была "garbage" и не разобралась, поэтому
+	// должна сохраниться предыдущая валидная скорость (1.0x).
+	if p.speed != 1.0 {
+		t.Fatalf("speed = %v, want 1.0 (garbage speed line must not overwrite it)", p.speed)
+	}
+}
+
+func TestProgressParserFeedIntermediate(t *testing.T) {
+	p := &progressParser{duration: 100 * time.Second}
+
+	p.feed("out_time_ms=25000")
+	p.feed("speed=0.5x")
+	p.feed("malformed garbage")
+	p.feed("out_time_ms=not_a_number")
+
+	if got := p.percent(); got != 25 {
+		t.Fatalf("percent() = %v, want 25", got)
+	}
+	if got := p.eta(); got != 150*time.Second {
+		t.Fatalf("eta() = %v, want 150s (75s remaining at 0.5x)", got)
+	}
+}
+
+func TestProgressParserZeroDuration(t *testing.T) {
+	p := &progressParser{}
+	p.feed("out_time_ms=25000000")
+	p.feed("speed=1.0x")
+
+	if got := p.percent(); got != 0 {
+		t.Fatalf("percent() = %v, want 0 when duration is unknown", got)
+	}
+	if got := p.eta(); got != 0 {
+		t.Fatalf("eta() = %v, want 0 when duration is unknown", got)
+	}
+}
+
+// fakeReporter собирает обновления прогресса для проверки WatchProgress.
+type fakeReporter struct {
+	updates []ProgressUpdate
+}
+
+func (f *fakeReporter) Report(update ProgressUpdate) {
+	f.updates = append(f.updates, update)
+}
+
+func TestWatchProgress(t *testing.T) {
+	reporter := &fakeReporter{}
+	r := strings.NewReader(strings.Join(recordedProgressLines, "\n"))
+
+	WatchProgress(r, "video.mov", 100*time.Second, reporter)
+
+	if len(reporter.updates) == 0 {
+		t.Fatalf("WatchProgress() reported no updates")
+	}
+
+	last := reporter.updates[len(reporter.updates)-1]
+	if !last.Done {
+		t.Fatalf("last update Done = false, want true after progress=end")
+	}
+	if last.File != "video.mov" {
+		t.Fatalf("last update File = %q, want %q", last.File, "video.mov")
+	}
+}
+
+func TestWatchProgressNilReporter(t *testing.T) {
+	// Не должно паниковать, если reporter не задан.
+	WatchProgress(strings.NewReader("out_time_ms=1000\n"), "video.mov", time.Second, nil)
+}
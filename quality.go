@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sourceProbe - параметры исходного файла, полученные ffprobe перед конвертацией,
+// используемые для пропуска уже достаточно сжатых файлов и подбора CRF.
+type sourceProbe struct {
+	VideoCodec string
+	Width      int
+	Height     int
+	BitRate    int64         // бит/с, из format.bit_rate или битрейта видеопотока
+	Duration   time.Duration // длительность из format.duration, используется вместо отдельного вызова progress.ProbeDuration
+}
+
+// ffprobeJSON - часть вывода `ffprobe -show_streams -show_format -of json`, нужная
+// для заполнения sourceProbe.
+type ffprobeJSON struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+		BitRate   string `json:"bit_rate"`
+	} `json:"streams"`
+	Format struct {
+		BitRate  string `json:"bit_rate"`
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// probeSource запускает ffprobe и возвращает кодек и разрешение первого видеопотока
+// исходного файла, длительность из format.duration и битрейт из format.bit_rate
+// (битрейт видеопотока берется, только если общий битрейт не указан).
+func probeSource(path string) (sourceProbe, error) {
+	out, err := exec.Command(ffprobePath,
+		"-v", "error",
+		"-show_streams",
+		"-show_format",
+		"-of", "json",
+		path,
+	).Output()
+	if err != nil {
+		return sourceProbe{}, fmt.Errorf("ffprobe: %w", err)
+	}
+
+	var parsed ffprobeJSON
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return sourceProbe{}, fmt.Errorf("разбор вывода ffprobe: %w", err)
+	}
+
+	probe := sourceProbe{}
+	if br, err := strconv.ParseInt(parsed.Format.BitRate, 10, 64); err == nil {
+		probe.BitRate = br
+	}
+	if seconds, err := strconv.ParseFloat(parsed.Format.Duration, 64); err == nil {
+		probe.Duration = time.Duration(seconds * float64(time.Second))
+	}
+
+	for _, s := range parsed.Streams {
+		if s.CodecType != "video" {
+			continue
+		}
+		probe.VideoCodec = s.CodecName
+		probe.Width = s.Width
+		probe.Height = s.Height
+		if probe.BitRate == 0 {
+			if br, err := strconv.ParseInt(s.BitRate, 10, 64); err == nil {
+				probe.BitRate = br
+			}
+		}
+		break
+	}
+
+	return probe, nil
+}
+
+// skipReason сообщает, почему файл с такими параметрами не нуждается в перекодировании
+// (уже в AV1 или битрейт ниже minBitrate), либо возвращает пустую строку.
+func skipReason(probe sourceProbe, minBitrate int64) string {
+	if strings.Contains(strings.ToLower(probe.VideoCodec), "av1") {
+		return "исходник уже в AV1"
+	}
+	if minBitrate > 0 && probe.BitRate > 0 && probe.BitRate < minBitrate {
+		return fmt.Sprintf("битрейт исходника (%d бит/с) ниже порога --min-bitrate (%d бит/с)", probe.BitRate, minBitrate)
+	}
+	return ""
+}
+
+// crfAdjustFlags - аргументы управления качеством, значение которых подстраивается
+// под разрешение/битрейт исходника (см. adaptiveProfile).
+var crfAdjustFlags = map[string]bool{"-crf": true, "-cq": true, "-qp": true}
+
+// adaptiveProfile подстраивает CRF/CQ/QP профиля под разрешение и битрейт исходника:
+// у 4K и высокобитрейтных исходников забираем CRF ниже (выше качество), у низкого
+// разрешения и низкого битрейта - выше (меньше размер), чтобы не пережимать то,
+// что и так хорошо сжато, и не терять качество на плотном материале.
+func adaptiveProfile(profile Profile, probe sourceProbe) Profile {
+	var delta int
+	switch {
+	case probe.Width >= 3840:
+		delta -= 2
+	case probe.Width > 0 && probe.Width <= 1280:
+		delta += 2
+	}
+	switch {
+	case probe.BitRate >= 20_000_000:
+		delta -= 2
+	case probe.BitRate > 0 && probe.BitRate <= 2_000_000:
+		delta += 2
+	}
+	if delta == 0 {
+		return profile
+	}
+
+	rc := append([]string(nil), profile.RateControl...)
+	for i := 0; i+1 < len(rc); i += 2 {
+		if !crfAdjustFlags[rc[i]] {
+			continue
+		}
+		v, err := strconv.Atoi(rc[i+1])
+		if err != nil {
+			continue
+		}
+		v += delta
+		switch {
+		case v < 0:
+			v = 0
+		case v > 51:
+			v = 51
+		}
+		rc[i+1] = strconv.Itoa(v)
+	}
+
+	profile.RateControl = rc
+	return profile
+}
+
+// vmafScorePattern разбирает строку вида "VMAF score: 95.123456" из вывода
+// ffmpeg/libvmaf.
+var vmafScorePattern = regexp.MustCompile(`VMAF score:\s*([0-9.]+)`)
+
+// measureVMAF прогоняет второй проход ffmpeg с фильтром libvmaf, сравнивая
+// outputPath с sourcePath, и возвращает посчитанную оценку VMAF.
+func measureVMAF(sourcePath, outputPath string) (float64, error) {
+	out, err := exec.Command(ffmpegPath,
+		"-i", outputPath,
+		"-i", sourcePath,
+		"-lavfi", "libvmaf",
+		"-f", "null", "-",
+	).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("ffmpeg libvmaf: %w", err)
+	}
+
+	matches := vmafScorePattern.FindStringSubmatch(string(out))
+	if len(matches) < 2 {
+		return 0, errors.New("не удалось разобрать оценку VMAF из вывода ffmpeg")
+	}
+
+	return strconv.ParseFloat(matches[1], 64)
+}
+
+// qualityRejection проверяет успешно сконвертированный файл против порогов
+// --max-size-ratio и --min-vmaf (если они заданы) и возвращает причину отказа,
+// либо пустую строку, если результат проходит все включенные проверки. Ошибка
+// самого измерения VMAF не отклоняет результат, а только предупреждает в лог,
+// чтобы отсутствие libvmaf в сборке ffmpeg не валило всю обработку.
+func qualityRejection(jobOpts jobOptions, fileName, sourcePath, outputPath string, sourceBytes, outputBytes int64) string {
+	if jobOpts.maxSizeRatio > 0 && sourceBytes > 0 {
+		ratio := float64(outputBytes) / float64(sourceBytes)
+		if ratio > jobOpts.maxSizeRatio {
+			return fmt.Sprintf("размер результата (%d байт) превышает --max-size-ratio %.2f относительно исходника (%d байт, фактическое отношение %.2f)",
+				outputBytes, jobOpts.maxSizeRatio, sourceBytes, ratio)
+		}
+	}
+
+	if jobOpts.minVMAF > 0 {
+		score, err := measureVMAF(sourcePath, outputPath)
+		if err != nil {
+			log.Printf("[ПРЕДУПРЕЖДЕНИЕ] Не удалось измерить VMAF для %s: %v", fileName, err)
+			return ""
+		}
+		fmt.Printf("[VMAF] %s: %.2f\n", filepath.Base(outputPath), score)
+		if score < jobOpts.minVMAF {
+			return fmt.Sprintf("VMAF %.2f ниже порога --min-vmaf %.2f", score, jobOpts.minVMAF)
+		}
+	}
+
+	return ""
+}
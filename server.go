@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"crawler-video-converter/progress"
+)
+
+// apiServer реализует HTTP API, включаемое флагом --listen: мониторинг и отмену
+// отдельных задач конвертации, запуск повторного обхода каталога и экспорт метрик
+// Prometheus для многочасовых батчей, запущенных на headless-сервере.
+type apiServer struct {
+	pm       *ProcessManager
+	reporter *progress.AggregateProgress
+	rootPath string
+	profile  Profile
+	threads  int
+	jobs     int
+	jobOpts  jobOptions
+
+	scanning int32 // 0/1, атомарный флаг, чтобы не запускать два обхода одновременно
+}
+
+// newAPIServer создает apiServer поверх уже открытых pm/store и параметров обработки,
+// разрешенных в main так же, как для одноразового запуска.
+func newAPIServer(pm *ProcessManager, rootPath string, profile Profile, threads, jobs int, jobOpts jobOptions) *apiServer {
+	return &apiServer{
+		pm:       pm,
+		reporter: progress.NewAggregateProgress(),
+		rootPath: rootPath,
+		profile:  profile,
+		threads:  threads,
+		jobs:     jobs,
+		jobOpts:  jobOpts,
+	}
+}
+
+// routes собирает обработчики HTTP API.
+func (s *apiServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/scan", s.handleScan)
+	mux.HandleFunc("/jobs", s.handleJobs)
+	mux.HandleFunc("/jobs/", s.handleJob)
+	return mux
+}
+
+// handleHealthz сообщает, что процесс жив и может принимать запросы.
+func (s *apiServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// jobView - представление JobRecord для JSON-ответов API, дополненное id (ключом хранилища).
+type jobView struct {
+	ID string `json:"id"`
+	JobRecord
+}
+
+// handleJobs отдает все известные хранилищу задачи.
+func (s *apiServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	all := s.jobOpts.store.all()
+	out := make([]jobView, 0, len(all))
+	for id, rec := range all {
+		out = append(out, jobView{ID: id, JobRecord: rec})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleJob обслуживает GET /jobs/{id} и POST /jobs/{id}/cancel.
+func (s *apiServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+
+	if cancelID, ok := strings.CutSuffix(id, "/cancel"); ok {
+		if r.Method != http.MethodPost {
+			http.Error(w, "метод не поддерживается", http.StatusMethodNotAllowed)
+			return
+		}
+		if !s.pm.CancelJob(cancelID) {
+			http.Error(w, "задача не выполняется", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "canceling"})
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rec, ok := s.jobOpts.store.get(id)
+	if !ok {
+		http.Error(w, "задача не найдена", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, jobView{ID: id, JobRecord: rec})
+}
+
+// handleScan запускает обход rootPath и конвертацию найденных файлов в фоне, отвечая
+// сразу, не дожидаясь завершения батча. Параллельный повторный запуск отклоняется,
+// чтобы несколько обходов не создавали конкурирующих воркеров по одним и тем же файлам.
+func (s *apiServer) handleScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&s.scanning, 0, 1) {
+		http.Error(w, "обход каталога уже выполняется", http.StatusConflict)
+		return
+	}
+
+	go s.runScan()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+}
+
+// startScan запускает первый обход каталога при старте сервера, так же, как это
+// делает одноразовый запуск без --listen.
+func (s *apiServer) startScan() {
+	if !atomic.CompareAndSwapInt32(&s.scanning, 0, 1) {
+		return
+	}
+	go s.runScan()
+}
+
+// runScan ищет видео файлы в rootPath и обрабатывает их, снимая флаг scanning по завершении.
+func (s *apiServer) runScan() {
+	defer atomic.StoreInt32(&s.scanning, 0)
+
+	files, err := findVideoFiles(s.rootPath, s.profile.sourceExts())
+	if err != nil {
+		log.Printf("[ОШИБКА] Обход каталога %s: %v", s.rootPath, err)
+		return
+	}
+	if len(files) == 0 {
+		log.Printf("Обход каталога %s: видео файлы не найдены", s.rootPath)
+		return
+	}
+
+	log.Printf("Обход каталога %s: найдено %d файлов для обработки", s.rootPath, len(files))
+	if err := processFiles(files, s.pm, s.threads, s.jobs, s.profile, s.jobOpts, s.reporter); err != nil {
+		log.Printf("[ОШИБКА] Обработка каталога %s: %v", s.rootPath, err)
+	}
+}
+
+// handleMetrics отдает метрики в текстовом формате Prometheus.
+func (s *apiServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var filesTotal, convertedTotal, failedTotal int
+	var bytesSavedTotal int64
+	for _, rec := range s.jobOpts.store.all() {
+		filesTotal++
+		switch rec.Status {
+		case JobDone:
+			convertedTotal++
+			if saved := rec.SourceBytes - rec.OutputBytes; saved > 0 {
+				bytesSavedTotal += saved
+			}
+		case JobFailed:
+			failedTotal++
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintf(w, "# HELP crawler_files_total Количество файлов с известным состоянием в хранилище задач.\n")
+	fmt.Fprintf(w, "# TYPE crawler_files_total gauge\n")
+	fmt.Fprintf(w, "crawler_files_total %d\n", filesTotal)
+
+	fmt.Fprintf(w, "# HELP crawler_converted_total Количество успешно сконвертированных файлов.\n")
+	fmt.Fprintf(w, "# TYPE crawler_converted_total counter\n")
+	fmt.Fprintf(w, "crawler_converted_total %d\n", convertedTotal)
+
+	fmt.Fprintf(w, "# HELP crawler_failed_total Количество файлов, завершившихся с ошибкой.\n")
+	fmt.Fprintf(w, "# TYPE crawler_failed_total counter\n")
+	fmt.Fprintf(w, "crawler_failed_total %d\n", failedTotal)
+
+	fmt.Fprintf(w, "# HELP crawler_bytes_saved_total Разница размеров исходных и сконвертированных файлов.\n")
+	fmt.Fprintf(w, "# TYPE crawler_bytes_saved_total counter\n")
+	fmt.Fprintf(w, "crawler_bytes_saved_total %d\n", bytesSavedTotal)
+
+	fmt.Fprintf(w, "# HELP crawler_current_jobs Количество задач конвертации, выполняющихся сейчас.\n")
+	fmt.Fprintf(w, "# TYPE crawler_current_jobs gauge\n")
+	fmt.Fprintf(w, "crawler_current_jobs %d\n", s.pm.ActiveJobs())
+
+	fmt.Fprintf(w, "# HELP crawler_ffmpeg_speed Средняя скорость кодирования активных задач (во сколько раз быстрее реального времени).\n")
+	fmt.Fprintf(w, "# TYPE crawler_ffmpeg_speed gauge\n")
+	fmt.Fprintf(w, "crawler_ffmpeg_speed %f\n", s.reporter.AverageSpeed())
+}
+
+// writeJSON сериализует v в JSON и пишет его с указанным статусом.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[ОШИБКА] Кодирование JSON-ответа: %v", err)
+	}
+}